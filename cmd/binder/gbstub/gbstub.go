@@ -0,0 +1,127 @@
+// Package gbstub renders the Goby source stub that documents a
+// generated binding: a class declaration with one method stub per
+// bound method, so IDEs and Goby's own docs pipeline have something to
+// index without having to understand the Go side of the binding.
+package gbstub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/NaokiM03/goby/cmd/binder/loader"
+	"github.com/NaokiM03/goby/cmd/binder/marshal"
+)
+
+// Render builds the .gb stub source for b.
+func Render(b *loader.Binding) string {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "class %s\n", b.ExternalName)
+	for _, m := range b.ClassMethods {
+		renderMethod(&out, m, true)
+	}
+	for _, m := range b.InstanceMethods {
+		renderMethod(&out, m, false)
+	}
+	out.WriteString("end\n")
+
+	return out.String()
+}
+
+func renderMethod(out *strings.Builder, m *loader.Method, classMethod bool) {
+	for _, line := range docLines(m) {
+		fmt.Fprintf(out, "  # %s\n", line)
+	}
+	if summary := argSummary(m); summary != "" {
+		fmt.Fprintf(out, "  # %s\n", summary)
+	}
+
+	prefix := ""
+	if classMethod {
+		prefix = "self."
+	}
+
+	fmt.Fprintf(out, "  def %s%s(%s); end\n\n", prefix, m.Name(), paramList(m))
+}
+
+// docLines returns a method's Go doc comment, one line per comment
+// line, with the leading "//" stripped. goby: directive lines are left
+// out.
+func docLines(m *loader.Method) []string {
+	if m.Decl.Doc == nil {
+		return nil
+	}
+
+	var lines []string
+	for _, c := range m.Decl.Doc.List {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+		if strings.HasPrefix(text, directivePrefix) {
+			continue
+		}
+		lines = append(lines, text)
+	}
+	return lines
+}
+
+const directivePrefix = "goby:"
+
+// paramList renders the Goby parameter list for a method: every bound
+// parameter except the leading *vm.Thread and any block parameter,
+// which the doc summary notes separately as "(takes a block)".
+func paramList(m *loader.Method) string {
+	params := m.Sig.Params()
+	var names []string
+	for i := 0; i < params.Len(); i++ {
+		if i == 0 {
+			continue
+		}
+		p := params.At(i)
+		if loader.IsBlockType(p.Type(), m.VMObject) {
+			continue
+		}
+		name := p.Name()
+		if name == "" {
+			name = fmt.Sprintf("arg%d", i-1)
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// argSummary renders a one-line comment describing the expected
+// argument types, as reported by the marshal package, plus whether the
+// method takes a block.
+func argSummary(m *loader.Method) string {
+	params := m.Sig.Params()
+	var types []string
+	block := false
+
+	for i := 0; i < params.Len(); i++ {
+		if i == 0 {
+			continue
+		}
+		t := params.At(i).Type()
+		switch {
+		case loader.IsBlockType(t, m.VMObject):
+			block = true
+		case loader.IsVMObject(t, m.VMObject):
+			types = append(types, "Object")
+		default:
+			if s := marshal.TypeSummary(t); s != "" {
+				types = append(types, s)
+			}
+		}
+	}
+
+	summary := ""
+	if len(types) > 0 {
+		summary = "@param " + strings.Join(types, ", ")
+	}
+	if block {
+		if summary != "" {
+			summary += " "
+		}
+		summary += "(takes a block)"
+	}
+	return summary
+}
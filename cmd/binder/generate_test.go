@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	. "github.com/dave/jennifer/jen"
+
+	"github.com/NaokiM03/goby/cmd/binder/loader"
+)
+
+// TestBindMethodsCompiles drives the whole generator end to end: it
+// loads a sample package exercising required, optional-scalar, and
+// optional-vm.Object parameters, runs BindMethods on every binding
+// loader.Load finds, and actually builds the result with the real go
+// toolchain against a local stand-in vm module. This is the only test
+// in the series that builds real output, rather than rendering
+// jennifer's Code tree and scanning the string for fragments - the
+// class of check that let both the unwrapVia Clone bug and the
+// zeroDecl untyped-nil bug reach review.
+func TestBindMethodsCompiles(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	dir := t.TempDir()
+	writeStubModule(t, dir)
+
+	sampleDir := filepath.Join(dir, "sample")
+	if err := os.MkdirAll(sampleDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sampleDir, "widget.go"), []byte(sampleSrc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bindings, err := loader.Load(sampleDir)
+	if err != nil {
+		t.Fatalf("loader.Load: %v", err)
+	}
+
+	f := NewFile("sample")
+	for _, b := range bindings {
+		if len(b.ClassMethods)+len(b.InstanceMethods) == 0 {
+			continue
+		}
+		BindMethods(b, f, "sample", "widget.gb")
+	}
+
+	out := filepath.Join(sampleDir, "widget_bindings.go")
+	if err := f.Save(out); err != nil {
+		t.Fatalf("save bindings: %v", err)
+	}
+
+	cmd := exec.Command("go", "build", "./...")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated bindings don't compile: %v\n%s", err, output)
+	}
+}
+
+const sampleSrc = `package sample
+
+import "github.com/goby-lang/goby/vm"
+
+// Widget is a sample Goby-bound type exercising required and optional
+// parameters end to end.
+type Widget struct {
+	name string
+}
+
+// Greet takes a required string and an optional vm.Object.
+//goby:optional obj
+func (w *Widget) Greet(t *vm.Thread, name string, obj vm.Object) string {
+	return name
+}
+
+// Repeat takes an optional plain int.
+//goby:optional n
+func (w *Widget) Repeat(t *vm.Thread, n int) int {
+	return n
+}
+
+func (w *Widget) Class() *vm.Class { return nil }
+`
+
+func writeStubModule(t *testing.T, dir string) {
+	t.Helper()
+
+	mustWrite(t, filepath.Join(dir, "go.mod"), `module sample
+
+go 1.21
+
+require github.com/goby-lang/goby v0.0.0
+
+replace github.com/goby-lang/goby => ./vmstub
+`)
+
+	vmstub := filepath.Join(dir, "vmstub")
+	mustWrite(t, filepath.Join(vmstub, "go.mod"), "module github.com/goby-lang/goby\n\ngo 1.21\n")
+	mustWrite(t, filepath.Join(vmstub, "vm", "vm.go"), vmStubSrc)
+	mustWrite(t, filepath.Join(vmstub, "vm", "errors", "errors.go"), errorsStubSrc)
+}
+
+func mustWrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+package loader
+
+import (
+	"go/ast"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/camelcase"
+)
+
+// Directives capture the //goby: comment annotations a method's doc
+// comment may carry, overriding the generator's default conventions for
+// naming, arity, and inclusion.
+type Directives struct {
+	Name       string   // goby:name  - registered name, instead of the snake_cased Go name
+	Aliases    []string // goby:alias - extra names registered for the same binding
+	Class      string   // goby:class - register under this class instead of the receiver's
+	Skip       bool     // goby:skip  - omit this method from the generated bindings
+	Optional   []string // goby:optional - parameter names that may be omitted from the call
+	Implements string   // goby:implements - on a type's doc comment, the Goby module its methods must satisfy
+
+	// ArityMin and ArityMax come from goby:arity, e.g. "1..3" or a bare
+	// "2". ArityMax of -1 means unbounded. Both are -1 when unset, in
+	// which case the generator falls back to the method's exact Go
+	// parameter count.
+	ArityMin int
+	ArityMax int
+}
+
+const directivePrefix = "goby:"
+
+// parseDirectives reads the //goby: lines out of a doc comment. Lines
+// that aren't directives (ordinary prose) are ignored.
+func parseDirectives(doc *ast.CommentGroup) Directives {
+	d := Directives{ArityMin: -1, ArityMax: -1}
+	if doc == nil {
+		return d
+	}
+
+	for _, c := range doc.List {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+		if !strings.HasPrefix(text, directivePrefix) {
+			continue
+		}
+		text = strings.TrimSpace(strings.TrimPrefix(text, directivePrefix))
+
+		key, arg := text, ""
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			key, arg = text[:i], strings.TrimSpace(text[i+1:])
+		}
+
+		switch key {
+		case "name":
+			d.Name = arg
+		case "class":
+			d.Class = arg
+		case "skip":
+			d.Skip = true
+		case "alias":
+			d.Aliases = splitList(arg)
+		case "optional":
+			d.Optional = splitList(arg)
+		case "arity":
+			d.ArityMin, d.ArityMax = parseArity(arg)
+		case "implements":
+			d.Implements = arg
+		}
+	}
+
+	return d
+}
+
+// Name returns the name this method is registered under in Goby: the
+// goby:name directive when set, otherwise its Go name lowered and
+// snake_cased.
+func (m *Method) Name() string {
+	if m.Directives.Name != "" {
+		return m.Directives.Name
+	}
+	x := camelcase.Split(m.Decl.Name.Name)
+	return strings.ToLower(strings.Join(x, "_"))
+}
+
+// Names returns every name this method should be reachable under: its
+// primary name plus any goby:alias entries, all pointing at the same
+// generated binding function.
+func (m *Method) Names() []string {
+	return append([]string{m.Name()}, m.Directives.Aliases...)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// parseArity parses a goby:arity argument, either "min..max" (max may be
+// empty for "unbounded") or a single bare number meaning exactly that
+// many arguments.
+func parseArity(s string) (min, max int) {
+	if i := strings.Index(s, ".."); i >= 0 {
+		min = atoiOr(strings.TrimSpace(s[:i]), 0)
+		rest := strings.TrimSpace(s[i+2:])
+		if rest == "" {
+			return min, -1
+		}
+		return min, atoiOr(rest, min)
+	}
+	n := atoiOr(strings.TrimSpace(s), 0)
+	return n, n
+}
+
+func atoiOr(s string, fallback int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
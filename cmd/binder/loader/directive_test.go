@@ -0,0 +1,109 @@
+package loader
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a, b", []string{"a", "b"}},
+		{"a,  b ,c", []string{"a", "b", "c"}},
+		{" , ", nil},
+	}
+
+	for _, c := range cases {
+		if got := splitList(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitList(%q) = %#v, want %#v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseArity(t *testing.T) {
+	cases := []struct {
+		in       string
+		min, max int
+	}{
+		{"2", 2, 2},
+		{"1..3", 1, 3},
+		{"1..", 1, -1},
+		{"..", 0, -1},
+		{"bogus", 0, 0},
+	}
+
+	for _, c := range cases {
+		min, max := parseArity(c.in)
+		if min != c.min || max != c.max {
+			t.Errorf("parseArity(%q) = (%d, %d), want (%d, %d)", c.in, min, max, c.min, c.max)
+		}
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	doc := parseComment(t, `// goby:name foo
+// goby:alias bar, baz
+// goby:class Other
+// goby:skip
+// goby:optional a, b
+// goby:arity 1..3
+// goby:implements Enumerable
+// this line is plain prose and should be ignored
+`)
+
+	d := parseDirectives(doc)
+
+	if d.Name != "foo" {
+		t.Errorf("Name = %q, want foo", d.Name)
+	}
+	if !reflect.DeepEqual(d.Aliases, []string{"bar", "baz"}) {
+		t.Errorf("Aliases = %#v, want [bar baz]", d.Aliases)
+	}
+	if d.Class != "Other" {
+		t.Errorf("Class = %q, want Other", d.Class)
+	}
+	if !d.Skip {
+		t.Error("Skip = false, want true")
+	}
+	if !reflect.DeepEqual(d.Optional, []string{"a", "b"}) {
+		t.Errorf("Optional = %#v, want [a b]", d.Optional)
+	}
+	if d.ArityMin != 1 || d.ArityMax != 3 {
+		t.Errorf("Arity = (%d, %d), want (1, 3)", d.ArityMin, d.ArityMax)
+	}
+	if d.Implements != "Enumerable" {
+		t.Errorf("Implements = %q, want Enumerable", d.Implements)
+	}
+}
+
+func TestParseDirectivesDefaultsOnNilDoc(t *testing.T) {
+	d := parseDirectives(nil)
+	if d.ArityMin != -1 || d.ArityMax != -1 {
+		t.Errorf("Arity = (%d, %d), want (-1, -1) when there's no doc comment", d.ArityMin, d.ArityMax)
+	}
+}
+
+// parseComment parses src as a standalone doc comment attached to a
+// dummy declaration, and returns the resulting *ast.CommentGroup.
+func parseComment(t *testing.T, src string) *ast.CommentGroup {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "x.go", "package p\n\n"+src+"type T struct{}", parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Doc != nil {
+			return gd.Doc
+		}
+	}
+	t.Fatal("no doc comment found in parsed source")
+	return nil
+}
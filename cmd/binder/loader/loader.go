@@ -0,0 +1,273 @@
+// Package loader loads a Go package with full type information so the
+// binder can resolve argument and return types across package
+// boundaries (imports, aliases, interface satisfaction) instead of
+// matching on raw AST syntax.
+package loader
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+
+	"github.com/NaokiM03/goby/cmd/binder/marshal"
+)
+
+const vmObjectPkg = "github.com/goby-lang/goby/vm"
+
+// Method pairs a method's syntax with its type-checked signature, so
+// callers can resolve parameter and result types through *types.Info
+// rather than re-deriving them from the AST.
+type Method struct {
+	Decl       *ast.FuncDecl
+	Sig        *types.Signature
+	Directives Directives
+
+	// VMObject is the vm.Object interface type reached through this
+	// method's package, for use with IsVMObject and IsBlockType. It's
+	// nil if that package's import graph never reaches vm.Object.
+	VMObject *types.Interface
+}
+
+// Binding holds context about a struct that represents a goby class.
+type Binding struct {
+	ClassName       string
+	ExternalName    string    // registered class name, overridable via a goby:class directive
+	Implements      string    // goby:implements on the type's doc comment, if any
+	ClassMethods    []*Method // Any method whose receiver is unnamed is a class method func (Class) myFunc
+	InstanceMethods []*Method // Any method whose receiver is named is an instance method func (c *Class) myFunc
+
+	// VMObject is the vm.Object interface type reached through this
+	// binding's package, for use with IsVMObject and IsBlockType.
+	VMObject *types.Interface
+
+	Pkg  *packages.Package
+	Info *types.Info
+}
+
+const loadMode = packages.NeedName | packages.NeedImports | packages.NeedDeps |
+	packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+
+// Load type-checks every Go file in dir and returns one Binding per
+// struct type declared there, populated with any methods whose results
+// can be returned to Goby (see returnsBindable).
+func Load(dir string) ([]*Binding, error) {
+	cfg := &packages.Config{
+		Mode: loadMode,
+		Dir:  dir,
+	}
+
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return nil, fmt.Errorf("loader: load %s: %w", dir, err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		return nil, fmt.Errorf("loader: %s has type errors", dir)
+	}
+
+	bindings := make(map[string]*Binding)
+	var order []string
+
+	get := func(name string, pkg *packages.Package) *Binding {
+		b, ok := bindings[name]
+		if !ok {
+			b = &Binding{ClassName: name, ExternalName: name, Pkg: pkg, Info: pkg.TypesInfo, VMObject: vmObjectInterface(pkg)}
+			bindings[name] = b
+			order = append(order, name)
+		}
+		return b
+	}
+
+	for _, pkg := range pkgs {
+		vmObject := vmObjectInterface(pkg)
+
+		for _, f := range pkg.Syntax {
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.FuncDecl:
+					if n.Recv == nil {
+						return true
+					}
+
+					obj, _ := pkg.TypesInfo.Defs[n.Name].(*types.Func)
+					if obj == nil {
+						return true
+					}
+					sig := obj.Type().(*types.Signature)
+					if !returnsBindable(sig, vmObject) {
+						return true
+					}
+
+					dirs := parseDirectives(n.Doc)
+					if dirs.Skip {
+						return true
+					}
+
+					r := n.Recv.List[0]
+					b := get(receiverName(r.Type), pkg)
+					if dirs.Class != "" {
+						b.ExternalName = dirs.Class
+					}
+
+					m := &Method{Decl: n, Sig: sig, Directives: dirs, VMObject: vmObject}
+					if r.Names == nil {
+						b.ClassMethods = append(b.ClassMethods, m)
+					} else {
+						b.InstanceMethods = append(b.InstanceMethods, m)
+					}
+
+				case *ast.GenDecl:
+					if n.Tok != token.TYPE {
+						return true
+					}
+					for _, spec := range n.Specs {
+						ts, ok := spec.(*ast.TypeSpec)
+						if !ok {
+							continue
+						}
+						if _, ok := ts.Type.(*ast.StructType); !ok {
+							continue
+						}
+						b := get(ts.Name.Name, pkg)
+						if dirs := parseDirectives(typeDoc(n, ts)); dirs.Implements != "" {
+							b.Implements = dirs.Implements
+						}
+					}
+				}
+
+				return true
+			})
+		}
+	}
+
+	out := make([]*Binding, 0, len(order))
+	for _, name := range order {
+		out = append(out, bindings[name])
+	}
+	return out, nil
+}
+
+// returnsBindable reports whether sig's results look like they could be
+// exposed to Goby: either a plain vm.Object, or a result shape the
+// marshal package can box on its own (one native value, optionally
+// followed by a trailing error).
+func returnsBindable(sig *types.Signature, vmObject *types.Interface) bool {
+	res := sig.Results()
+	switch res.Len() {
+	case 1:
+		return isBindableResult(res.At(0).Type(), vmObject)
+	case 2:
+		return res.At(1).Type().String() == "error" && marshal.CanWrap(res.At(0).Type())
+	default:
+		return false
+	}
+}
+
+// isBindableResult reports whether t is something body() can actually
+// return to Goby: a vm.Object, or a native type marshal.Wrap knows how
+// to box.
+func isBindableResult(t types.Type, vmObject *types.Interface) bool {
+	return IsVMObject(t, vmObject) || marshal.CanWrap(t)
+}
+
+// IsVMObject reports whether t satisfies vm.Object: either because t is
+// that interface itself, or because its method set implements it, which
+// covers concrete VM subtypes such as *vm.StringObject as well as any
+// other type satisfying the interface. vmObject is nil when the loaded
+// package's import graph never reaches vm.Object, in which case nothing
+// can be one.
+func IsVMObject(t types.Type, vmObject *types.Interface) bool {
+	if vmObject == nil {
+		return false
+	}
+	return types.Implements(t, vmObject)
+}
+
+// IsBlockType reports whether t is the callback signature a bound Go
+// method uses to receive a Goby block argument: func(*vm.Thread,
+// ...vm.Object) vm.Object, written directly or via an alias such as
+// goby.Block.
+func IsBlockType(t types.Type, vmObject *types.Interface) bool {
+	sig, ok := t.Underlying().(*types.Signature)
+	if !ok || !sig.Variadic() || sig.Params().Len() != 2 || sig.Results().Len() != 1 {
+		return false
+	}
+	if !IsVMObject(sig.Results().At(0).Type(), vmObject) {
+		return false
+	}
+
+	thread, ok := sig.Params().At(0).Type().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := thread.Elem().(*types.Named)
+	if !ok || named.Obj().Name() != "Thread" || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != vmObjectPkg {
+		return false
+	}
+
+	objs, ok := sig.Params().At(1).Type().(*types.Slice)
+	return ok && IsVMObject(objs.Elem(), vmObject)
+}
+
+// vmObjectInterface locates vm.Object's interface type by walking pkg's
+// import graph for the vm package, so IsVMObject can check interface
+// satisfaction instead of comparing names. It returns nil if pkg never
+// reaches the vm package at all.
+func vmObjectInterface(pkg *packages.Package) *types.Interface {
+	vm := findImport(pkg, vmObjectPkg)
+	if vm == nil || vm.Types == nil {
+		return nil
+	}
+	obj := vm.Types.Scope().Lookup("Object")
+	if obj == nil {
+		return nil
+	}
+	iface, _ := obj.Type().Underlying().(*types.Interface)
+	return iface
+}
+
+// findImport searches pkg and everything it imports, transitively, for
+// the package at path.
+func findImport(pkg *packages.Package, path string) *packages.Package {
+	seen := make(map[string]bool)
+	var walk func(p *packages.Package) *packages.Package
+	walk = func(p *packages.Package) *packages.Package {
+		if seen[p.PkgPath] {
+			return nil
+		}
+		seen[p.PkgPath] = true
+		if p.PkgPath == path {
+			return p
+		}
+		for _, imp := range p.Imports {
+			if found := walk(imp); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	return walk(pkg)
+}
+
+// typeDoc returns the doc comment that applies to ts: its own Doc when
+// it's one of several specs grouped under a single "type (...)" block,
+// otherwise decl's, which is where go/parser attaches it for the
+// ordinary "// doc\ntype Foo struct{}" form.
+func typeDoc(decl *ast.GenDecl, ts *ast.TypeSpec) *ast.CommentGroup {
+	if ts.Doc != nil {
+		return ts.Doc
+	}
+	return decl.Doc
+}
+
+func receiverName(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
@@ -1,24 +1,38 @@
+// Command goby-bindings generates Goby class bindings for Go structs.
+//
+// By default it walks the whole package in -in and generates one
+// <snake_case_type>_bindings.go plus a matching .gb stub for every
+// struct with at least one bindable method. Pass -type to restrict
+// generation to a single struct.
+//
+// A package can drive this from `go generate` the same way it would
+// stringer or gqlgen:
+//
+//	//go:generate goby-bindings
 package main
 
 import (
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
+	"go/types"
 	"io/ioutil"
 	"log"
 	"strings"
 
 	"github.com/fatih/camelcase"
 
+	"github.com/NaokiM03/goby/cmd/binder/gbstub"
+	"github.com/NaokiM03/goby/cmd/binder/loader"
+	"github.com/NaokiM03/goby/cmd/binder/marshal"
+
 	// makes writing this easier
 	. "github.com/dave/jennifer/jen"
 )
 
 var (
 	in       = flag.String("in", ".", "folder to create bindings from")
-	typeName = flag.String("type", "", "type to generate bindings for")
+	typeName = flag.String("type", "", "restrict generation to this type (default: every bindable type in the package)")
+	outGb    = flag.String("out-gb", "", "path to write the companion .gb stub to, when only one binding is generated (default <snake_case_type>.gb)")
 )
 
 const (
@@ -26,103 +40,251 @@ const (
 	errorsPkg = "github.com/goby-lang/goby/vm/errors"
 )
 
-func typeFromExpr(e ast.Expr) string {
-	var name string
-	switch t := e.(type) {
-	case *ast.Ident:
-		name = t.Name
-
-	case *ast.StarExpr:
-		name = fmt.Sprintf("*%s", typeFromExpr(t.X))
+// typeExpr renders a resolved *types.Type as jen code, qualifying it
+// with its defining import path when it isn't a builtin so that types
+// pulled in from other packages (or reached through an alias) come out
+// with the right selector instead of a bare, unresolvable name.
+func typeExpr(t types.Type) *Statement {
+	switch t := t.(type) {
+	case *types.Basic:
+		return Id(t.Name())
 
-	case *ast.SelectorExpr:
-		name = fmt.Sprintf("%s.%s", typeFromExpr(t.X), t.Sel.Name)
+	case *types.Pointer:
+		return Op("*").Add(typeExpr(t.Elem()))
 
-	}
-	return name
-}
+	case *types.Slice:
+		return Index().Add(typeExpr(t.Elem()))
 
-func typeNameFromExpr(e ast.Expr) string {
-	var name string
-	switch t := e.(type) {
-	case *ast.Ident:
-		name = t.Name
+	case *types.Map:
+		return Map(typeExpr(t.Key())).Add(typeExpr(t.Elem()))
 
-	case *ast.StarExpr:
-		name = typeFromExpr(t.X)
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil {
+			return Id(obj.Name())
+		}
+		return Qual(obj.Pkg().Path(), obj.Name())
 
-	case *ast.SelectorExpr:
-		name = fmt.Sprintf("%s.%s", typeFromExpr(t.X), t.Sel.Name)
+	default:
+		return Id(t.String())
+	}
+}
 
+// bareTypeName returns the unqualified name of a resolved type, for use
+// in messages where a plain identifier reads better than a full
+// import-qualified type string.
+func bareTypeName(t types.Type) string {
+	switch t := t.(type) {
+	case *types.Pointer:
+		return "*" + bareTypeName(t.Elem())
+	case *types.Named:
+		return t.Obj().Name()
+	case *types.Basic:
+		return t.Name()
+	default:
+		return t.String()
 	}
-	return name
 }
 
 type argPair struct {
-	name, kind string
+	name string
+	typ  types.Type
 }
 
-func allArgs(f *ast.FieldList) []argPair {
+func allArgs(sig *types.Signature) []argPair {
 	var args []argPair
-	for _, l := range f.List {
-		for _, n := range l.Names {
-			args = append(args, argPair{
-				name: n.Name,
-				kind: typeNameFromExpr(l.Type),
-			})
-		}
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		p := params.At(i)
+		args = append(args, argPair{name: p.Name(), typ: p.Type()})
 	}
-
 	return args
 }
 
 // Binding holds context about a struct that represents a goby class.
-type Binding struct {
-	ClassName       string
-	ClassMethods    []*ast.FuncDecl // Any method defined without a pointer reciever is a class method func (Class) myFunc
-	InstanceMethods []*ast.FuncDecl // Any method defined with a pointer reciever is an instance method func (c *Class) myFunc
-
-}
+type Binding = loader.Binding
 
-func (b *Binding) staticName() string {
+func staticName(b *Binding) string {
 	return fmt.Sprintf("static%s", b.ClassName)
 }
 
-func (b *Binding) bindingName(f *ast.FuncDecl) string {
-	return fmt.Sprintf("binding%s%s", b.ClassName, f.Name.Name)
+func bindingName(b *Binding, m *loader.Method) string {
+	return fmt.Sprintf("binding%s%s", b.ClassName, m.Decl.Name.Name)
 }
 
 // BindMethods generates code that binds methods of a go structure to a goby class
-func (b *Binding) BindMethods(f *File, x *ast.File) {
-	f.Add(mapping(b, x.Name.Name))
-	f.Var().Id(b.staticName()).Op("=").New(Id(b.ClassName))
+func BindMethods(b *Binding, f *File, pkgName, gbFile string) {
+	f.Add(mapping(b, pkgName, gbFile))
+	if b.Implements != "" {
+		f.Line()
+		f.Add(implementsCheck(b))
+	}
+	f.Var().Id(staticName(b)).Op("=").New(Id(b.ClassName))
 	for _, c := range b.ClassMethods {
-		b.BindClassMethod(f, c)
+		BindClassMethod(b, f, c)
 		f.Line()
 	}
 	for _, c := range b.InstanceMethods {
-		b.BindInstanceMethod(f, c)
+		BindInstanceMethod(b, f, c)
 		f.Line()
 	}
 }
 
 // BindClassMethod will generate class method bindings.
 // This is a global static method associated with the class.
-func (b *Binding) BindClassMethod(f *File, d *ast.FuncDecl) {
-	r := Id("r").Op(":=").Id(b.staticName()).Line()
-	b.body(r, f, d)
+func BindClassMethod(b *Binding, f *File, m *loader.Method) {
+	r := Id("r").Op(":=").Id(staticName(b)).Line()
+	body(b, r, f, m)
 }
 
 // BindInstanceMethod will generate instance method bindings.
 // This function will be bound to a spesific instantation of a goby class.
-func (b *Binding) BindInstanceMethod(f *File, d *ast.FuncDecl) {
+func BindInstanceMethod(b *Binding, f *File, m *loader.Method) {
 	r := List(Id("r"), Id("ok")).Op(":=").Add(Id("receiver")).Assert(Op("*").Id(b.ClassName)).Line()
 	r = r.If(Op("!").Id("ok")).Block(
 		Panic(
 			Qual("fmt", "Sprintf").Call(Lit("Impossible receiver type. Wanted "+b.ClassName+" got %s"), Id("receiver")),
 		),
 	).Line()
-	b.body(r, f, d)
+	body(b, r, f, m)
+}
+
+// bindBlock generates the statements that turn a Goby block attached to
+// the call into a Go closure matching the block-callback signature,
+// bailing out with an ArgumentError if the caller didn't pass one.
+func bindBlock(name string) Code {
+	return If(Op("!").Id("t").Dot("BlockGiven").Call()).Block(
+		Return(Id("t").Dot("VM").Call().Dot("InitErrorObject").Call(
+			Qual(errorsPkg, "ArgumentError"),
+			Id("line"),
+			Qual(errorsPkg, "CantYieldWithoutBlockFormat"),
+		)),
+	).Line().
+		Id("blockFrame").Op(":=").Id("t").Dot("BlockFrame").Call().Line().
+		Id(name).Op(":=").Func().Params(
+		Id("thread").Op("*").Qual(vmPkg, "Thread"),
+		Id("objs").Op("...").Qual(vmPkg, "Object"),
+	).Qual(vmPkg, "Object").Block(
+		Return(Id("t").Dot("Yield").Call(Id("blockFrame"), Id("objs").Op("..."))),
+	)
+}
+
+// implementsCheck generates the sanity check a goby:implements directive
+// asks for: an init-time check that every method name the declared
+// module expects is registered for this class, as either a class or
+// instance method. A refactor that silently drops or renames a method
+// then fails fast at program start instead of at the method's first
+// call. There's no Go-level interface this plugs into: binding methods
+// are registered by name into a map[string]vm.Method, not dispatched
+// through any fixed method set on *b.ClassName, so this check is the
+// only place goby:implements is enforced at all.
+func implementsCheck(b *Binding) Code {
+	registered := Dict{}
+	for _, d := range b.ClassMethods {
+		for _, name := range d.Names() {
+			registered[Lit(name)] = True()
+		}
+	}
+	for _, d := range b.InstanceMethods {
+		for _, name := range d.Names() {
+			registered[Lit(name)] = True()
+		}
+	}
+
+	return Func().Id("init").Params().Block(
+		Id("expected").Op(":=").Qual(vmPkg, "ModuleMethodNames").Call(Lit(b.Implements)),
+		Id("registered").Op(":=").Map(String()).Bool().Values(registered),
+		For(List(Id("_"), Id("name")).Op(":=").Range().Id("expected")).Block(
+			If(Op("!").Id("registered").Index(Id("name"))).Block(
+				Panic(Qual("fmt", "Sprintf").Call(
+					Lit(fmt.Sprintf("%s: missing method %%q required by %s", b.ClassName, b.Implements)),
+					Id("name"),
+				)),
+			),
+		),
+	)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// arityCheck builds the args-length guard for a binding. A fixed arity
+// (min == max) keeps the plain "!= N" check; a range from a goby:arity
+// directive, or from trailing goby:optional parameters, becomes
+// "< min || > max", with no upper bound when max is -1.
+func arityCheck(min, max int) *Statement {
+	if min == max {
+		return Len(Id("args")).Op("!=").Lit(min)
+	}
+	cond := Len(Id("args")).Op("<").Lit(min)
+	if max >= 0 {
+		cond = cond.Op("||").Len(Id("args")).Op(">").Lit(max)
+	}
+	return cond
+}
+
+// bindOptionalArg generates a declaration for a goby:optional
+// parameter: its native zero value when the caller didn't pass that
+// many arguments, otherwise the value unwrapped from index i.
+func bindOptionalArg(name string, i int, t types.Type, vmObject *types.Interface) (Code, error) {
+	decl := zeroDecl(name, t)
+	objExpr := Id("args").Index(Lit(i))
+
+	if loader.IsVMObject(t, vmObject) {
+		return decl.Line().
+			If(Len(Id("args")).Op(">").Lit(i)).Block(
+			Id(name).Op("=").Add(objExpr),
+		), nil
+	}
+
+	raw := name + "Raw"
+	unwrap, err := marshal.Unwrap(raw, objExpr, t)
+	if err != nil {
+		return nil, err
+	}
+	return decl.Line().
+		If(Len(Id("args")).Op(">").Lit(i)).Block(
+		unwrap,
+		Line(),
+		Id(name).Op("=").Id(raw),
+	), nil
+}
+
+// zeroDecl declares name seeded with t's Go zero value, used for an
+// optional argument the caller omitted. Types whose zero value is nil
+// (vm.Object and other interfaces, pointers, slices, maps) need an
+// explicit var declaration with t's type spelled out, since Go rejects
+// an untyped nil on the right-hand side of :=.
+func zeroDecl(name string, t types.Type) *Statement {
+	if lit, ok := zeroLiteral(t); ok {
+		return Id(name).Op(":=").Add(lit)
+	}
+	return Var().Id(name).Add(typeExpr(t))
+}
+
+// zeroLiteral returns the literal Go zero value for t, when t has one.
+func zeroLiteral(t types.Type) (Code, bool) {
+	if b, ok := t.(*types.Basic); ok {
+		switch b.Kind() {
+		case types.Int:
+			return Lit(0), true
+		case types.Int64:
+			return Int64().Call(Lit(0)), true
+		case types.String:
+			return Lit(""), true
+		case types.Bool:
+			return False(), true
+		case types.Float64:
+			return Lit(0.0), true
+		}
+	}
+	return nil, false
 }
 
 func wrongArgNum(want int) Code {
@@ -145,9 +307,13 @@ func wrongArgType(name, want string) Code {
 	))
 }
 
-// body is a helper function for generating the common body of a method
-func (b *Binding) body(receiver *Statement, f *File, d *ast.FuncDecl) {
-	s := f.Func().Id(b.bindingName(d))
+// body is a helper function for generating the common body of a method.
+// Arguments and results that aren't already a vm.Object are marshaled
+// to and from their native Go type via the marshal package, so a bound
+// method can be written as ordinary idiomatic Go.
+func body(b *Binding, receiver *Statement, f *File, m *loader.Method) {
+	d := m.Decl
+	s := f.Func().Id(bindingName(b, m))
 	s = s.Params(
 		Id("receiver").Qual(vmPkg, "Object"),
 		Id("line").Id("int"),
@@ -155,56 +321,116 @@ func (b *Binding) body(receiver *Statement, f *File, d *ast.FuncDecl) {
 		Id("args").Index().Qual(vmPkg, "Object"),
 	).Qual(vmPkg, "Object")
 
-	var args []*Statement
-	for i, a := range allArgs(d.Type.Params) {
+	params := allArgs(m.Sig)
+	dirs := m.Directives
+
+	// A block parameter isn't passed through the Goby args array at all
+	// (the block arrives out-of-band via t.BlockGiven()/t.BlockFrame()),
+	// so it doesn't count toward the arity check or consume an index.
+	total, required := 0, 0
+	for i, a := range params {
+		if i == 0 || loader.IsBlockType(a.typ, b.VMObject) {
+			continue
+		}
+		total++
+		if !contains(dirs.Optional, a.name) {
+			required++
+		}
+	}
+	arityMin, arityMax := required, total
+	if dirs.ArityMin != -1 {
+		arityMin, arityMax = dirs.ArityMin, dirs.ArityMax
+	}
+
+	var stmts []Code
+	argNames := []Code{Id("t")}
+	argIndex := 0
+	for i, a := range params {
 		if i == 0 {
 			continue
 		}
-		i = i - 1
-		c := List(Id(fmt.Sprintf("arg%d", i)), Id("ok")).Op(":=").Id("args").Index(Lit(i)).Assert(Id(a.kind))
-		c = c.Line()
-		c = c.If(Op("!").Id("ok")).Block(
-			Panic(Lit(fmt.Sprintf("Argument %d must be %s", i, a.kind))),
-		).Line()
-		args = append(args, c)
+		name := fmt.Sprintf("arg%d", i-1)
+		optional := contains(dirs.Optional, a.name)
+
+		switch {
+		case loader.IsBlockType(a.typ, b.VMObject):
+			stmts = append(stmts, bindBlock(name))
+
+		case optional:
+			c, err := bindOptionalArg(name, argIndex, a.typ, b.VMObject)
+			if err != nil {
+				log.Fatalf("%s.%s: %v", b.ClassName, d.Name.Name, err)
+			}
+			stmts = append(stmts, c)
+			argIndex++
+
+		case loader.IsVMObject(a.typ, b.VMObject):
+			objExpr := Id("args").Index(Lit(argIndex))
+			c := List(Id(name), Id("ok")).Op(":=").Add(objExpr).Assert(typeExpr(a.typ)).Line()
+			c = c.If(Op("!").Id("ok")).Block(
+				Panic(Lit(fmt.Sprintf("Argument %d must be %s", argIndex, bareTypeName(a.typ)))),
+			)
+			stmts = append(stmts, c)
+			argIndex++
+
+		case marshal.CanUnwrap(a.typ):
+			objExpr := Id("args").Index(Lit(argIndex))
+			c, err := marshal.Unwrap(name, objExpr, a.typ)
+			if err != nil {
+				log.Fatalf("%s.%s: %v", b.ClassName, d.Name.Name, err)
+			}
+			stmts = append(stmts, c)
+			argIndex++
+
+		default:
+			log.Fatalf("%s.%s: unsupported parameter type %s for argument %d", b.ClassName, d.Name.Name, bareTypeName(a.typ), argIndex)
+		}
+
+		argNames = append(argNames, Id(name))
 	}
 
-	inner := receiver.If(Len(Id("args")).Op("!=").Lit(d.Type.Params.NumFields() - 1)).Block(
-		wrongArgNum(d.Type.Params.NumFields() - 1),
+	inner := receiver.If(arityCheck(arityMin, arityMax)).Block(
+		wrongArgNum(arityMin),
 	).Line()
-	argNames := []Code{
-		Id("t"),
+	for _, c := range stmts {
+		inner = inner.Add(c).Line()
 	}
-	for i, a := range args {
-		inner = inner.Add(a).Line()
-		argNames = append(argNames, Id(fmt.Sprintf("arg%d", i)))
+
+	call := Id("r").Dot(d.Name.Name).Call(argNames...)
+	results := m.Sig.Results()
+	if results.Len() == 1 && loader.IsVMObject(results.At(0).Type(), b.VMObject) {
+		inner = inner.Return(call)
+	} else {
+		tail, err := marshal.WrapResults(call, results)
+		if err != nil {
+			log.Fatalf("%s.%s: %v", b.ClassName, d.Name.Name, err)
+		}
+		inner = inner.Add(tail)
 	}
 
-	inner = inner.Return(Id("r").Dot(d.Name.Name).Call(argNames...))
 	s.Block(inner)
 }
 
 // mapping generates the "init" portion of the bindings.
 // This will call hooks in the vm package to load the class definition at runtime.
-func mapping(b *Binding, pkg string) Code {
-	fnName := func(s string) string {
-		x := camelcase.Split(s)
-		return strings.ToLower(strings.Join(x, "_"))
-	}
-
+func mapping(b *Binding, pkg, gbFile string) Code {
 	cm := Dict{}
 	for _, d := range b.ClassMethods {
-		cm[Lit(fnName(d.Name.Name))] = Id(b.bindingName(d))
+		for _, name := range d.Names() {
+			cm[Lit(name)] = Id(bindingName(b, d))
+		}
 	}
 	im := Dict{}
 	for _, d := range b.InstanceMethods {
-		im[Lit(fnName(d.Name.Name))] = Id(b.bindingName(d))
+		for _, name := range d.Names() {
+			im[Lit(name)] = Id(bindingName(b, d))
+		}
 	}
 	dm := Qual(vmPkg, "RegisterExternalClass").Call(
 		Line().Lit(pkg),
 		Qual(vmPkg, "ExternalClass").Call(
-			Line().Lit(b.ClassName),
-			Line().Lit(pkg+".gb"),
+			Line().Lit(b.ExternalName),
+			Line().Lit(gbFile),
 			Line().Map(String()).Qual(vmPkg, "Method").Values(cm),
 			Line().Map(String()).Qual(vmPkg, "Method").Values(im),
 		),
@@ -218,71 +444,58 @@ func mapping(b *Binding, pkg string) Code {
 func main() {
 	flag.Parse()
 
-	fs := token.NewFileSet()
-	buff, err := ioutil.ReadFile(*in)
+	bindings, err := loader.Load(*in)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	f, err := parser.ParseFile(fs, *in, string(buff), parser.AllErrors)
-	if err != nil {
-		log.Fatal(err)
+	var targets []*Binding
+	for _, b := range bindings {
+		if len(b.ClassMethods)+len(b.InstanceMethods) == 0 {
+			continue
+		}
+		if *typeName != "" && b.ClassName != *typeName {
+			continue
+		}
+		targets = append(targets, b)
+	}
+	if len(targets) == 0 {
+		log.Fatalf("no bindable type named %q found in %s", *typeName, *in)
 	}
 
-	bindings := make(map[string]*Binding)
-
-	// iterate though every node in the ast looking for function definitions
-	ast.Inspect(f, func(n ast.Node) bool {
-		switch n := n.(type) {
-		case *ast.FuncDecl:
-			if n.Recv != nil {
-				res := n.Type.Results
-				if res == nil {
-					return true
-				}
-
-				if len(res.List) == 0 || typeNameFromExpr(res.List[0].Type) != "Object" {
-					return true
-				}
-
-				// class or instance?
-				r := n.Recv.List[0]
-				name := typeNameFromExpr(r.Type)
-
-				b, ok := bindings[name]
-				if !ok {
-					b = new(Binding)
-					b.ClassName = name
-					bindings[name] = b
-				}
-
-				// class
-				if r.Names == nil {
-					b.ClassMethods = append(b.ClassMethods, n)
-				} else {
-					b.InstanceMethods = append(b.InstanceMethods, n)
-				}
-			}
-		case *ast.TypeSpec:
-			bindings[n.Name.Name] = &Binding{
-				ClassName: n.Name.Name,
-			}
-
+	for _, bnd := range targets {
+		gbOverride := ""
+		if len(targets) == 1 {
+			gbOverride = *outGb
 		}
+		generate(bnd, gbOverride)
+	}
+}
 
-		return true
-	})
-
-	bnd, ok := bindings[*typeName]
-	if !ok {
-		log.Fatal("Uknown type", *typeName)
+// generate writes a single binding's <snake_case_type>_bindings.go and
+// its companion .gb stub.
+func generate(bnd *Binding, gbOverride string) {
+	gbFile := gbOverride
+	if gbFile == "" {
+		gbFile = snakeCase(bnd.ClassName) + ".gb"
 	}
 
-	o := NewFile(f.Name.Name)
-	bnd.BindMethods(o, f)
+	o := NewFile(bnd.Pkg.Name)
+	BindMethods(bnd, o, bnd.Pkg.Name, gbFile)
 
-	err = o.Save("bindings.go")
-	if err != nil {
+	outFile := snakeCase(bnd.ClassName) + "_bindings.go"
+	if err := o.Save(outFile); err != nil {
 		log.Fatal(err)
 	}
+
+	if err := ioutil.WriteFile(gbFile, []byte(gbstub.Render(bnd)), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// snakeCase converts a Go type name such as HTTPClient into the
+// snake_case form used for its generated filenames (http_client).
+func snakeCase(s string) string {
+	x := camelcase.Split(s)
+	return strings.ToLower(strings.Join(x, "_"))
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// TestBindOptionalArgTypeChecks renders bindOptionalArg's output for an
+// optional vm.Object-satisfying parameter and an optional plain scalar,
+// and type-checks both against a stand-in vm package. zeroDecl used to
+// fall back to Nil() for any non-basic type, which bindOptionalArg then
+// spliced into "argN := nil" - invalid Go, since := can't bind an
+// untyped nil. render-and-scan tests wouldn't catch this: the output
+// parses fine, it just doesn't compile.
+func TestBindOptionalArgTypeChecks(t *testing.T) {
+	vmObjectType, vmObjectIface := stubVMObjectType(t)
+
+	cases := []struct {
+		name string
+		typ  types.Type
+	}{
+		{"vm.Object", vmObjectType},
+		{"int", types.Typ[types.Int]},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, err := bindOptionalArg("arg0", 0, c.typ, vmObjectIface)
+			if err != nil {
+				t.Fatalf("bindOptionalArg: %v", err)
+			}
+			typeCheckBinding(t, code, Id("_").Op("=").Id("arg0"))
+		})
+	}
+}
+
+// typeCheckBinding renders code inside a function whose t, line and
+// args parameters have the same types as in every generated binding
+// method (t *vm.Thread, line int, args []vm.Object), followed by after,
+// and type-checks the result against stubVMPackages.
+func typeCheckBinding(t *testing.T, code Code, after Code) {
+	t.Helper()
+
+	f := NewFile("example")
+	f.Func().Id("binding").Params(
+		Id("t").Op("*").Qual(vmPkg, "Thread"),
+		Id("line").Int(),
+		Id("args").Index().Qual(vmPkg, "Object"),
+	).Qual(vmPkg, "Object").Block(
+		code,
+		after,
+		Return(Nil()),
+	)
+
+	var buf strings.Builder
+	if err := f.Render(&buf); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	src := buf.String()
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "example.go", src, 0)
+	if err != nil {
+		t.Fatalf("parse %s: %v", src, err)
+	}
+
+	conf := types.Config{Importer: stubImporter{}}
+	if _, err := conf.Check("example", fset, []*ast.File{file}, nil); err != nil {
+		t.Fatalf("type-check %s: %v", src, err)
+	}
+}
+
+// stubVMObjectType looks up the vm.Object named type in the
+// type-checked vm stub and returns it plus its underlying interface, so tests can pass a
+// parameter type that's really the vm.Object interface rather than a
+// basic type - the shape bindOptionalArg gets for e.g. a `//goby:optional
+// obj` parameter declared as `obj vm.Object`.
+func stubVMObjectType(t *testing.T) (types.Type, *types.Interface) {
+	t.Helper()
+	pkg := stubVMPackages[vmPkg]
+	obj := pkg.Scope().Lookup("Object")
+	if obj == nil {
+		t.Fatal("vm stub has no Object type")
+	}
+	iface, ok := obj.Type().Underlying().(*types.Interface)
+	if !ok {
+		t.Fatalf("vm.Object underlying = %T, want *types.Interface", obj.Type().Underlying())
+	}
+	return obj.Type(), iface
+}
+
+// stubImporter resolves vmPkg and errorsPkg to the minimal stand-ins
+// built by stubVMPackages, so rendered bindings can be type-checked
+// without the real vm module (this repo has no go.mod of its own).
+type stubImporter struct{}
+
+func (stubImporter) Import(path string) (*types.Package, error) {
+	pkg, ok := stubVMPackages[path]
+	if !ok {
+		return nil, errUnknownStubPackage(path)
+	}
+	return pkg, nil
+}
+
+type errUnknownStubPackage string
+
+func (e errUnknownStubPackage) Error() string { return "stubImporter: unknown package " + string(e) }
+
+var stubVMPackages = map[string]*types.Package{
+	vmPkg:     mustCheckStub(vmPkg, vmStubSrc),
+	errorsPkg: mustCheckStub(errorsPkg, errorsStubSrc),
+}
+
+func mustCheckStub(path, src string) *types.Package {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path+".go", src, 0)
+	if err != nil {
+		panic(err)
+	}
+	pkg, err := (&types.Config{}).Check(path, fset, []*ast.File{file}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return pkg
+}
+
+const vmStubSrc = `package vm
+
+type Object interface{ Class() *Class }
+
+type Class struct{}
+
+func (c *Class) Name() string { return "" }
+
+type Thread struct{}
+
+func (t *Thread) VM() *VM             { return nil }
+func (t *Thread) BlockGiven() bool    { return false }
+func (t *Thread) BlockFrame() *Frame  { return nil }
+func (t *Thread) Yield(f *Frame, objs ...Object) Object { return nil }
+
+type Frame struct{}
+
+type VM struct{}
+
+func (v *VM) InitErrorObject(class string, line int, format string, args ...interface{}) Object {
+	return nil
+}
+func (v *VM) InitIntegerObject(i int) Object       { return nil }
+func (v *VM) InitStringObject(s string) Object     { return nil }
+func (v *VM) InitBoolObject(b bool) Object         { return nil }
+func (v *VM) InitFloatObject(f float64) Object     { return nil }
+func (v *VM) InitArrayObject(e interface{}) Object { return nil }
+func (v *VM) InitHashObject(p interface{}) Object  { return nil }
+
+type IntegerObject struct{ Value int }
+
+func (o *IntegerObject) Class() *Class { return nil }
+
+type StringObject struct{ Value string }
+
+func (o *StringObject) Class() *Class { return nil }
+
+type BooleanObject struct{ Value bool }
+
+func (o *BooleanObject) Class() *Class { return nil }
+
+type FloatObject struct{ Value float64 }
+
+func (o *FloatObject) Class() *Class { return nil }
+
+type ArrayObject struct{ Elements []interface{} }
+
+func (o *ArrayObject) Class() *Class { return nil }
+
+type HashObject struct{ Pairs map[string]interface{} }
+
+func (o *HashObject) Class() *Class { return nil }
+
+type ExternalClassDef struct {
+	Name            string
+	GbFile          string
+	ClassMethods    map[string]Method
+	InstanceMethods map[string]Method
+}
+
+type Method func(receiver Object, line int, t *Thread, args []Object) Object
+
+func ExternalClass(name, gbFile string, cm, im map[string]Method) ExternalClassDef {
+	return ExternalClassDef{name, gbFile, cm, im}
+}
+
+func RegisterExternalClass(pkg string, defs ...ExternalClassDef) {}
+
+func ModuleMethodNames(module string) []string { return nil }
+`
+
+const errorsStubSrc = `package errors
+
+const (
+	ArgumentError = "ArgumentError"
+	TypeError     = "TypeError"
+	InternalError = "InternalError"
+)
+
+const (
+	WrongNumberOfArgumentFormat = "wrong number of arguments"
+	WrongArgumentTypeFormat     = "wrong argument type"
+	CantYieldWithoutBlockFormat = "can't yield without a block"
+)
+`
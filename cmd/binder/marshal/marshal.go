@@ -0,0 +1,235 @@
+// Package marshal generates the unwrap/wrap code that lets a bound Go
+// method take and return ordinary native types instead of forcing every
+// parameter and result to already be a vm.Object subtype.
+package marshal
+
+import (
+	"fmt"
+	"go/types"
+
+	// makes writing this easier
+	. "github.com/dave/jennifer/jen"
+)
+
+const (
+	vmPkg     = "github.com/goby-lang/goby/vm"
+	errorsPkg = "github.com/goby-lang/goby/vm/errors"
+)
+
+// CanUnwrap reports whether t is a native Go type this package knows how
+// to extract from a vm.Object argument.
+func CanUnwrap(t types.Type) bool {
+	return supported(t)
+}
+
+// CanWrap reports whether t is a native Go type this package knows how
+// to box into a vm.Object return value.
+func CanWrap(t types.Type) bool {
+	return supported(t)
+}
+
+// supported reports whether t is one of the native Go types this
+// package knows how to marshal in either direction.
+func supported(t types.Type) bool {
+	if isByteSlice(t) {
+		return true
+	}
+	switch t := t.(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.Int, types.Int64, types.String, types.Bool, types.Float64:
+			return true
+		}
+	case *types.Slice:
+		return true
+	case *types.Map:
+		b, ok := t.Key().(*types.Basic)
+		return ok && b.Kind() == types.String
+	}
+	return false
+}
+
+// TypeSummary returns the Goby class name a marshaled Go type appears
+// as once wrapped (e.g. "Integer", "Array"), for use in documentation
+// such as the companion .gb stub. It returns "" for types this package
+// doesn't marshal.
+func TypeSummary(t types.Type) string {
+	if isByteSlice(t) {
+		return "String"
+	}
+	switch t := t.(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.Int, types.Int64:
+			return "Integer"
+		case types.String:
+			return "String"
+		case types.Bool:
+			return "Boolean"
+		case types.Float64:
+			return "Float"
+		}
+	case *types.Slice:
+		return "Array"
+	case *types.Map:
+		if b, ok := t.Key().(*types.Basic); ok && b.Kind() == types.String {
+			return "Hash"
+		}
+	}
+	return ""
+}
+
+// Unwrap generates the statements that assert objExpr to the vm.Object
+// class backing t, extract its native Go value into a variable called
+// name, and produce a TypeError if the assertion fails.
+func Unwrap(name string, objExpr *Statement, t types.Type) (Code, error) {
+	if isByteSlice(t) {
+		return unwrapVia(name, objExpr, "StringObject", func(v *Statement) *Statement {
+			return Index().Byte().Call(v.Dot("Value"))
+		}), nil
+	}
+
+	switch t := t.(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.Int:
+			return unwrapVia(name, objExpr, "IntegerObject", func(v *Statement) *Statement {
+				return v.Dot("Value")
+			}), nil
+		case types.Int64:
+			return unwrapVia(name, objExpr, "IntegerObject", func(v *Statement) *Statement {
+				return Int64().Call(v.Dot("Value"))
+			}), nil
+		case types.String:
+			return unwrapVia(name, objExpr, "StringObject", func(v *Statement) *Statement {
+				return v.Dot("Value")
+			}), nil
+		case types.Bool:
+			return unwrapVia(name, objExpr, "BooleanObject", func(v *Statement) *Statement {
+				return v.Dot("Value")
+			}), nil
+		case types.Float64:
+			return unwrapVia(name, objExpr, "FloatObject", func(v *Statement) *Statement {
+				return v.Dot("Value")
+			}), nil
+		}
+
+	case *types.Slice:
+		return unwrapVia(name, objExpr, "ArrayObject", func(v *Statement) *Statement {
+			return v.Dot("Elements")
+		}), nil
+
+	case *types.Map:
+		if b, ok := t.Key().(*types.Basic); !ok || b.Kind() != types.String {
+			break
+		}
+		return unwrapVia(name, objExpr, "HashObject", func(v *Statement) *Statement {
+			return v.Dot("Pairs")
+		}), nil
+	}
+
+	return nil, fmt.Errorf("marshal: cannot unwrap %s from a vm.Object", t)
+}
+
+// unwrapVia builds the common "assert to *vm.<class>Object, pull the
+// native value out via extract, or bail with a TypeError" shape shared
+// by every scalar and collection unwrap.
+func unwrapVia(name string, objExpr *Statement, class string, extract func(*Statement) *Statement) Code {
+	obj := Id(name + "Obj")
+	return List(obj, Id("ok")).Op(":=").Add(objExpr).Assert(Op("*").Qual(vmPkg, class)).Line().
+		If(Op("!").Id("ok")).Block(
+		Return(Id("t").Dot("VM").Call().Dot("InitErrorObject").Call(
+			Qual(errorsPkg, "TypeError"),
+			Id("line"),
+			Qual(errorsPkg, "WrongArgumentTypeFormat"),
+			Lit(class),
+			objExpr.Clone().Dot("Class").Call().Dot("Name"),
+		)),
+	).Line().
+		Id(name).Op(":=").Add(extract(obj.Clone()))
+}
+
+// Wrap generates an expression that boxes a native Go value held in
+// valueExpr into the vm.Object matching t, via the matching Init*Object
+// constructor on t.VM().
+func Wrap(valueExpr Code, t types.Type) (Code, error) {
+	if isByteSlice(t) {
+		return initCall("InitStringObject", String().Call(valueExpr)), nil
+	}
+
+	switch t := t.(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.Int:
+			return initCall("InitIntegerObject", valueExpr), nil
+		case types.Int64:
+			return initCall("InitIntegerObject", Int().Call(valueExpr)), nil
+		case types.String:
+			return initCall("InitStringObject", valueExpr), nil
+		case types.Bool:
+			return initCall("InitBoolObject", valueExpr), nil
+		case types.Float64:
+			return initCall("InitFloatObject", valueExpr), nil
+		}
+
+	case *types.Slice:
+		return initCall("InitArrayObject", valueExpr), nil
+
+	case *types.Map:
+		if b, ok := t.Key().(*types.Basic); ok && b.Kind() == types.String {
+			return initCall("InitHashObject", valueExpr), nil
+		}
+	}
+
+	return nil, fmt.Errorf("marshal: cannot wrap %s into a vm.Object", t)
+}
+
+func initCall(method string, arg Code) Code {
+	return Id("t").Dot("VM").Call().Dot(method).Call(arg)
+}
+
+// WrapResults generates the tail of a binding method: it calls the
+// bound Go method via call, and wraps whatever it returns into a
+// vm.Object. A trailing (T, error) result surfaces a non-nil error as
+// an InternalError instead of attempting to wrap it.
+func WrapResults(call Code, results *types.Tuple) (Code, error) {
+	switch results.Len() {
+	case 1:
+		w, err := Wrap(Id("result"), results.At(0).Type())
+		if err != nil {
+			return nil, err
+		}
+		return List(Id("result")).Op(":=").Add(call).Line().
+			Return(w), nil
+
+	case 2:
+		errT := results.At(1).Type()
+		if _, ok := errT.(*types.Basic); ok || errT.String() != "error" {
+			return nil, fmt.Errorf("marshal: second result must be error, got %s", errT)
+		}
+		w, err := Wrap(Id("result"), results.At(0).Type())
+		if err != nil {
+			return nil, err
+		}
+		return List(Id("result"), Id("err")).Op(":=").Add(call).Line().
+			If(Id("err").Op("!=").Nil()).Block(
+			Return(Id("t").Dot("VM").Call().Dot("InitErrorObject").Call(
+				Qual(errorsPkg, "InternalError"),
+				Id("line"),
+				Id("err").Dot("Error").Call(),
+			)),
+		).Line().
+			Return(w), nil
+	}
+
+	return nil, fmt.Errorf("marshal: unsupported result arity %d", results.Len())
+}
+
+func isByteSlice(t types.Type) bool {
+	s, ok := t.(*types.Slice)
+	if !ok {
+		return false
+	}
+	b, ok := s.Elem().(*types.Basic)
+	return ok && b.Kind() == types.Byte
+}
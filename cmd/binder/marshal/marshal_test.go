@@ -0,0 +1,293 @@
+package marshal
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+func TestSupported(t *testing.T) {
+	cases := []struct {
+		name string
+		typ  types.Type
+		want bool
+	}{
+		{"int", types.Typ[types.Int], true},
+		{"string", types.Typ[types.String], true},
+		{"bool", types.Typ[types.Bool], true},
+		{"float64", types.Typ[types.Float64], true},
+		{"byte slice", types.NewSlice(types.Typ[types.Byte]), true},
+		{"int slice", types.NewSlice(types.Typ[types.Int]), true},
+		{"string-keyed map", types.NewMap(types.Typ[types.String], types.Typ[types.Int]), true},
+		{"int-keyed map", types.NewMap(types.Typ[types.Int], types.Typ[types.String]), false},
+		{"complex128", types.Typ[types.Complex128], false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := supported(c.typ); got != c.want {
+				t.Errorf("supported(%s) = %v, want %v", c.name, got, c.want)
+			}
+			if got := CanWrap(c.typ); got != c.want {
+				t.Errorf("CanWrap(%s) = %v, want %v", c.name, got, c.want)
+			}
+			if got := CanUnwrap(c.typ); got != c.want {
+				t.Errorf("CanUnwrap(%s) = %v, want %v", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestWrapUnsupported(t *testing.T) {
+	if _, err := Wrap(Id("x"), types.Typ[types.Complex128]); err == nil {
+		t.Fatal("Wrap(complex128) err = nil, want an error")
+	}
+}
+
+func TestUnwrapUnsupported(t *testing.T) {
+	if _, err := Unwrap("x", Id("args").Index(Lit(0)), types.Typ[types.Complex128]); err == nil {
+		t.Fatal("Unwrap(complex128) err = nil, want an error")
+	}
+}
+
+func TestUnwrapRendersTypeAssertion(t *testing.T) {
+	code, err := Unwrap("arg0", Id("args").Index(Lit(0)), types.Typ[types.String])
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+
+	rendered := render(t, code)
+	if !strings.Contains(rendered, "*vm.StringObject") {
+		t.Errorf("Unwrap(string) rendered %q, want a *vm.StringObject assertion", rendered)
+	}
+}
+
+func TestWrapRendersInitCall(t *testing.T) {
+	code, err := Wrap(Id("result"), types.Typ[types.Int])
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	rendered := render(t, code)
+	if !strings.Contains(rendered, "InitIntegerObject") {
+		t.Errorf("Wrap(int) rendered %q, want an InitIntegerObject call", rendered)
+	}
+}
+
+func render(t *testing.T, code Code) string {
+	t.Helper()
+	f := NewFile("example")
+	f.Func().Id("f").Params().Block(code)
+	var buf strings.Builder
+	if err := f.Render(&buf); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return buf.String()
+}
+
+// TestUnwrapTypeChecks renders Unwrap's output for every type it
+// supports into a real binding-shaped function and type-checks it
+// against stand-ins for vm and vm/errors. render() above only proves
+// jennifer's formatter is happy with the Code tree; it previously let a
+// corrupted `arg0Obj.Value, ok := ...` assignment (the unwrapVia Clone
+// bug) through because strings.Contains can't tell a valid statement
+// from a mangled one.
+func TestUnwrapTypeChecks(t *testing.T) {
+	cases := []struct {
+		name string
+		typ  types.Type
+	}{
+		{"int", types.Typ[types.Int]},
+		{"int64", types.Typ[types.Int64]},
+		{"string", types.Typ[types.String]},
+		{"bool", types.Typ[types.Bool]},
+		{"float64", types.Typ[types.Float64]},
+		{"byte slice", types.NewSlice(types.Typ[types.Byte])},
+		{"int slice", types.NewSlice(types.Typ[types.Int])},
+		{"string-keyed map", types.NewMap(types.Typ[types.String], types.Typ[types.Int])},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, err := Unwrap("arg0", Id("args").Index(Lit(0)), c.typ)
+			if err != nil {
+				t.Fatalf("Unwrap: %v", err)
+			}
+			typeCheckBinding(t, code, Id("_").Op("=").Id("arg0"))
+		})
+	}
+}
+
+func TestWrapResultsTypeChecks(t *testing.T) {
+	cases := []struct {
+		name    string
+		results *types.Tuple
+		call    Code
+	}{
+		{
+			"one result",
+			types.NewTuple(types.NewVar(0, nil, "", types.Typ[types.String])),
+			Lit("x"),
+		},
+		{
+			"result and error",
+			types.NewTuple(
+				types.NewVar(0, nil, "", types.Typ[types.Int]),
+				types.NewVar(0, nil, "", types.Universe.Lookup("error").Type()),
+			),
+			Parens(Func().Params().Params(Int(), Error()).Block(
+				Return(Lit(0), Nil()),
+			)).Call(),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, err := WrapResults(c.call, c.results)
+			if err != nil {
+				t.Fatalf("WrapResults: %v", err)
+			}
+			typeCheckBinding(t, code, Empty())
+		})
+	}
+}
+
+// typeCheckBinding renders code inside a function whose t, line and
+// args parameters have the same types as in every generated binding
+// method (t *vm.Thread, line int, args []vm.Object), followed by after,
+// and type-checks the result against
+// stubVMPackages, so a statement that merely *parses* but doesn't
+// compile (e.g. a multi-assign whose LHS got mutated into a non-name
+// expression) fails the test.
+func typeCheckBinding(t *testing.T, code Code, after Code) {
+	t.Helper()
+
+	f := NewFile("example")
+	f.Func().Id("binding").Params(
+		Id("t").Op("*").Qual(vmPkg, "Thread"),
+		Id("line").Int(),
+		Id("args").Index().Qual(vmPkg, "Object"),
+	).Qual(vmPkg, "Object").Block(
+		code,
+		after,
+		Return(Nil()),
+	)
+
+	var buf strings.Builder
+	if err := f.Render(&buf); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	src := buf.String()
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "example.go", src, 0)
+	if err != nil {
+		t.Fatalf("parse %s: %v", src, err)
+	}
+
+	conf := types.Config{Importer: stubImporter{}}
+	if _, err := conf.Check("example", fset, []*ast.File{file}, nil); err != nil {
+		t.Fatalf("type-check %s: %v", src, err)
+	}
+}
+
+// stubImporter resolves vmPkg and errorsPkg to the minimal stand-ins
+// built by stubVMPackages, so rendered bindings can be type-checked
+// without the real vm module (this repo has no go.mod of its own).
+type stubImporter struct{}
+
+func (stubImporter) Import(path string) (*types.Package, error) {
+	pkg, ok := stubVMPackages[path]
+	if !ok {
+		return nil, fmt.Errorf("stubImporter: unknown package %q", path)
+	}
+	return pkg, nil
+}
+
+var stubVMPackages = map[string]*types.Package{
+	vmPkg:     mustCheckStub(vmPkg, vmStubSrc),
+	errorsPkg: mustCheckStub(errorsPkg, errorsStubSrc),
+}
+
+func mustCheckStub(path, src string) *types.Package {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path+".go", src, 0)
+	if err != nil {
+		panic(err)
+	}
+	pkg, err := (&types.Config{}).Check(path, fset, []*ast.File{file}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return pkg
+}
+
+const vmStubSrc = `package vm
+
+type Object interface{ Class() *Class }
+
+type Class struct{}
+
+func (c *Class) Name() string { return "" }
+
+type Thread struct{}
+
+func (t *Thread) VM() *VM { return nil }
+
+type VM struct{}
+
+func (v *VM) InitErrorObject(class string, line int, format string, args ...interface{}) Object {
+	return nil
+}
+func (v *VM) InitIntegerObject(i int) Object                  { return nil }
+func (v *VM) InitStringObject(s string) Object                { return nil }
+func (v *VM) InitBoolObject(b bool) Object                    { return nil }
+func (v *VM) InitFloatObject(f float64) Object                { return nil }
+func (v *VM) InitArrayObject(e interface{}) Object            { return nil }
+func (v *VM) InitHashObject(p interface{}) Object             { return nil }
+
+type IntegerObject struct{ Value int }
+
+func (o *IntegerObject) Class() *Class { return nil }
+
+type StringObject struct{ Value string }
+
+func (o *StringObject) Class() *Class { return nil }
+
+type BooleanObject struct{ Value bool }
+
+func (o *BooleanObject) Class() *Class { return nil }
+
+type FloatObject struct{ Value float64 }
+
+func (o *FloatObject) Class() *Class { return nil }
+
+type ArrayObject struct{ Elements []interface{} }
+
+func (o *ArrayObject) Class() *Class { return nil }
+
+type HashObject struct{ Pairs map[string]interface{} }
+
+func (o *HashObject) Class() *Class { return nil }
+`
+
+const errorsStubSrc = `package errors
+
+const (
+	ArgumentError = "ArgumentError"
+	TypeError     = "TypeError"
+	InternalError = "InternalError"
+)
+
+const (
+	WrongNumberOfArgumentFormat = "wrong number of arguments"
+	WrongArgumentTypeFormat     = "wrong argument type"
+	CantYieldWithoutBlockFormat = "can't yield without a block"
+)
+`